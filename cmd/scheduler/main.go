@@ -0,0 +1,37 @@
+// Command scheduler is the EventBridge-invoked Lambda entrypoint for the
+// scheduler package.
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+
+	"github.com/CraigDevJohnson/ec2_manager/scheduler"
+)
+
+// Event is the EventBridge scheduled event payload. Only the fields the
+// scheduler cares about are modeled here.
+type Event struct {
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+func handleRequest(ctx context.Context, event Event) (scheduler.Response, error) {
+	log.Printf("Running scheduler sweep: dry_run=%v", event.DryRun)
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return scheduler.Response{}, err
+	}
+
+	s := scheduler.New(ec2.NewFromConfig(cfg))
+	return s.Run(ctx, time.Now(), event.DryRun)
+}
+
+func main() {
+	lambda.Start(handleRequest)
+}
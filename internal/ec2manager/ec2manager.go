@@ -0,0 +1,546 @@
+// Package ec2manager drives EC2 instance lifecycle actions (start, stop,
+// restart, change instance type, describe) behind an injectable client, so
+// both the request-driven Lambda in the repo root and the scheduler package
+// can share the same logic instead of reimplementing it.
+package ec2manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+)
+
+// defaultConcurrency bounds how many instances RunConcurrent touches at once
+// when the caller does not specify its own concurrency.
+const defaultConcurrency = 5
+
+// InstanceResult captures the outcome of an action applied to a single
+// instance as part of a bulk or tag-selector request.
+type InstanceResult struct {
+	InstanceID string `json:"instance_id"`
+	Success    bool   `json:"success"`
+	Message    string `json:"message,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// InstanceInfo is the rich instance state returned by DescribeInstance, so a
+// dashboard can render it without a second API call.
+type InstanceInfo struct {
+	InstanceID         string            `json:"instance_id"`
+	State              string            `json:"state"`
+	InstanceType       string            `json:"instance_type"`
+	PrivateIPAddress   string            `json:"private_ip_address,omitempty"`
+	PublicIPAddress    string            `json:"public_ip_address,omitempty"`
+	AvailabilityZone   string            `json:"availability_zone,omitempty"`
+	LaunchTime         time.Time         `json:"launch_time,omitempty"`
+	Tags               map[string]string `json:"tags,omitempty"`
+	RootDeviceType     string            `json:"root_device_type,omitempty"`
+	IAMInstanceProfile string            `json:"iam_instance_profile,omitempty"`
+}
+
+// EC2API is the subset of the EC2 client Manager depends on. It exists so
+// tests can supply a fake in place of a real *ec2.Client, without requiring
+// AWS credentials.
+type EC2API interface {
+	StartInstances(ctx context.Context, params *ec2.StartInstancesInput, optFns ...func(*ec2.Options)) (*ec2.StartInstancesOutput, error)
+	StopInstances(ctx context.Context, params *ec2.StopInstancesInput, optFns ...func(*ec2.Options)) (*ec2.StopInstancesOutput, error)
+	DescribeInstances(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error)
+	ModifyInstanceAttribute(ctx context.Context, params *ec2.ModifyInstanceAttributeInput, optFns ...func(*ec2.Options)) (*ec2.ModifyInstanceAttributeOutput, error)
+	DescribeCapacityReservations(ctx context.Context, params *ec2.DescribeCapacityReservationsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeCapacityReservationsOutput, error)
+	CreateSnapshots(ctx context.Context, params *ec2.CreateSnapshotsInput, optFns ...func(*ec2.Options)) (*ec2.CreateSnapshotsOutput, error)
+}
+
+// Manager handles EC2 operations.
+type Manager struct {
+	client     EC2API
+	waitConfig WaitConfig
+}
+
+// New creates a Manager backed by a real EC2 client.
+func New(ctx context.Context) (*Manager, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load SDK config: %w", err)
+	}
+
+	return NewWithClient(ec2.NewFromConfig(cfg)), nil
+}
+
+// NewWithClient creates a Manager backed by the given client, letting tests
+// inject a fake that satisfies EC2API.
+func NewWithClient(client EC2API) *Manager {
+	return &Manager{client: client, waitConfig: DefaultWaitConfig()}
+}
+
+// SetWaitConfig overrides the wait behavior RestartInstance and
+// ChangeInstanceType use when polling for an instance to stop. Tests use
+// this to inject a fake waiter instead of polling AWS with real delays.
+func (m *Manager) SetWaitConfig(cfg WaitConfig) {
+	m.waitConfig = cfg
+}
+
+// InstanceStoppedWaiter is the subset of *ec2.InstanceStoppedWaiter that
+// Manager depends on, so tests can supply a fake that doesn't poll AWS.
+type InstanceStoppedWaiter interface {
+	Wait(ctx context.Context, params *ec2.DescribeInstancesInput, maxWaitDur time.Duration, optFns ...func(*ec2.InstanceStoppedWaiterOptions)) error
+}
+
+// WaitConfig controls how Manager waits for an instance to reach the
+// stopped state during RestartInstance and ChangeInstanceType.
+type WaitConfig struct {
+	// MaxWait bounds how long to wait for the instance to stop.
+	MaxWait time.Duration
+	// MinDelay and MaxDelay bound the interval between polls; the
+	// underlying waiter backs off between them with jitter.
+	MinDelay time.Duration
+	MaxDelay time.Duration
+	// WaiterFactory builds the waiter used to poll instance state, letting
+	// tests substitute one that doesn't hit AWS.
+	WaiterFactory func(client EC2API) InstanceStoppedWaiter
+}
+
+// DefaultWaitConfig returns the wait behavior used when a Manager isn't
+// given one explicitly: up to 5 minutes, polling every 2-30s with backoff.
+func DefaultWaitConfig() WaitConfig {
+	return WaitConfig{
+		MaxWait:       5 * time.Minute,
+		MinDelay:      2 * time.Second,
+		MaxDelay:      30 * time.Second,
+		WaiterFactory: defaultWaiterFactory,
+	}
+}
+
+func defaultWaiterFactory(client EC2API) InstanceStoppedWaiter {
+	return ec2.NewInstanceStoppedWaiter(client)
+}
+
+// waitDeadlineSafetyMargin is reserved so a shrunk wait still leaves time for
+// the caller to observe the error and return, rather than the Lambda being
+// killed mid-wait.
+const waitDeadlineSafetyMargin = 2 * time.Second
+
+// waitForStopped blocks until instanceID reaches the stopped state,
+// according to m.waitConfig. If the context carries a deadline that leaves
+// less time than MaxWait, the wait window shrinks to fit so the caller can
+// return a useful error instead of being killed mid-wait.
+func (m *Manager) waitForStopped(ctx context.Context, instanceID string) error {
+	cfg := m.waitConfig
+	maxWait := cfg.MaxWait
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if remaining := time.Until(deadline); remaining < maxWait {
+			maxWait = remaining - waitDeadlineSafetyMargin
+			if maxWait <= 0 {
+				return fmt.Errorf("not enough time remains before the Lambda deadline to wait for instance %s to stop", instanceID)
+			}
+		}
+	}
+
+	waiter := cfg.WaiterFactory(m.client)
+	if err := waiter.Wait(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: []string{instanceID},
+	}, maxWait, func(o *ec2.InstanceStoppedWaiterOptions) {
+		o.MinDelay = cfg.MinDelay
+		o.MaxDelay = cfg.MaxDelay
+	}); err != nil {
+		return fmt.Errorf("error waiting for instance to stop: %w", err)
+	}
+
+	return nil
+}
+
+// WaitForStopped blocks until instanceID is confirmed stopped. Exported so
+// callers (e.g. a plain stop request with wait_for set) can opt into
+// confirming the transition instead of firing and forgetting it.
+func (m *Manager) WaitForStopped(ctx context.Context, instanceID string) error {
+	return m.waitForStopped(ctx, instanceID)
+}
+
+// InstancesByTag resolves the instance IDs matching a tag:key=value filter
+// via a single DescribeInstances call.
+func (m *Manager) InstancesByTag(ctx context.Context, key, value string) ([]string, error) {
+	result, err := m.client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("tag:" + key),
+				Values: []string{value},
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve tag selector tag:%s=%s: %w", key, value, err)
+	}
+
+	var ids []string
+	for _, reservation := range result.Reservations {
+		for _, instance := range reservation.Instances {
+			ids = append(ids, aws.ToString(instance.InstanceId))
+		}
+	}
+	return ids, nil
+}
+
+// RunConcurrent applies action to every instance ID using a bounded worker
+// pool, so a failure on one instance doesn't block or abort the rest of the
+// fleet. Results are returned in the same order as instanceIDs.
+func RunConcurrent(instanceIDs []string, concurrency int, action func(instanceID string) (string, error)) []InstanceResult {
+	if concurrency <= 0 {
+		concurrency = defaultConcurrency
+	}
+
+	results := make([]InstanceResult, len(instanceIDs))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for i, id := range instanceIDs {
+		wg.Add(1)
+		go func(i int, instanceID string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			message, err := action(instanceID)
+			if err != nil {
+				results[i] = InstanceResult{InstanceID: instanceID, Success: false, Error: err.Error()}
+				return
+			}
+			results[i] = InstanceResult{InstanceID: instanceID, Success: true, Message: message}
+		}(i, id)
+	}
+
+	wg.Wait()
+	return results
+}
+
+// StartInstance starts an EC2 instance. When dryRun is true, EC2 is called
+// with DryRun: true and a nil error means "would have started" rather than
+// that the instance actually started.
+func (m *Manager) StartInstance(ctx context.Context, instanceID string, dryRun bool) error {
+	input := &ec2.StartInstancesInput{
+		InstanceIds: []string{instanceID},
+		DryRun:      aws.Bool(dryRun),
+	}
+
+	result, err := m.client.StartInstances(ctx, input)
+	if isDryRunOK(dryRun, err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to start instance: %w", err)
+	}
+
+	if len(result.StartingInstances) > 0 {
+		log.Printf("Instance %s state changing from %s to %s",
+			instanceID,
+			result.StartingInstances[0].PreviousState.Name,
+			result.StartingInstances[0].CurrentState.Name)
+	}
+
+	return nil
+}
+
+// StopInstance stops an EC2 instance. When dryRun is true, EC2 is called
+// with DryRun: true and a nil error means "would have stopped" rather than
+// that the instance actually stopped.
+func (m *Manager) StopInstance(ctx context.Context, instanceID string, dryRun bool) error {
+	input := &ec2.StopInstancesInput{
+		InstanceIds: []string{instanceID},
+		DryRun:      aws.Bool(dryRun),
+	}
+
+	result, err := m.client.StopInstances(ctx, input)
+	if isDryRunOK(dryRun, err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stop instance: %w", err)
+	}
+
+	if len(result.StoppingInstances) > 0 {
+		log.Printf("Instance %s state changing from %s to %s",
+			instanceID,
+			result.StoppingInstances[0].PreviousState.Name,
+			result.StoppingInstances[0].CurrentState.Name)
+	}
+
+	return nil
+}
+
+// isDryRunOK reports whether err is the expected DryRunOperation response to
+// a dry-run call, i.e. AWS validated the request and would have carried it
+// out rather than rejecting it outright.
+func isDryRunOK(dryRun bool, err error) bool {
+	if !dryRun || err == nil {
+		return false
+	}
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "DryRunOperation"
+}
+
+// DescribeInstance returns the rich state of a single EC2 instance.
+func (m *Manager) DescribeInstance(ctx context.Context, instanceID string) (*InstanceInfo, error) {
+	return describeInstance(ctx, m.client, instanceID)
+}
+
+// describeInstance holds the DescribeInstance logic against an EC2API so it
+// can be driven by a fake client in tests.
+func describeInstance(ctx context.Context, client EC2API, instanceID string) (*InstanceInfo, error) {
+	result, err := client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: []string{instanceID},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe instance: %w", err)
+	}
+
+	if len(result.Reservations) == 0 || len(result.Reservations[0].Instances) == 0 {
+		return nil, fmt.Errorf("instance %s not found", instanceID)
+	}
+
+	return buildInstanceInfo(result.Reservations[0].Instances[0]), nil
+}
+
+// buildInstanceInfo converts an SDK instance into the InstanceInfo shape
+// returned by DescribeInstance.
+func buildInstanceInfo(instance types.Instance) *InstanceInfo {
+	info := &InstanceInfo{
+		InstanceID:       aws.ToString(instance.InstanceId),
+		InstanceType:     string(instance.InstanceType),
+		PrivateIPAddress: aws.ToString(instance.PrivateIpAddress),
+		PublicIPAddress:  aws.ToString(instance.PublicIpAddress),
+		RootDeviceType:   string(instance.RootDeviceType),
+	}
+
+	if instance.State != nil {
+		info.State = string(instance.State.Name)
+	}
+	if instance.Placement != nil {
+		info.AvailabilityZone = aws.ToString(instance.Placement.AvailabilityZone)
+	}
+	if instance.LaunchTime != nil {
+		info.LaunchTime = *instance.LaunchTime
+	}
+	if instance.IamInstanceProfile != nil {
+		info.IAMInstanceProfile = aws.ToString(instance.IamInstanceProfile.Arn)
+	}
+	if len(instance.Tags) > 0 {
+		info.Tags = make(map[string]string, len(instance.Tags))
+		for _, tag := range instance.Tags {
+			info.Tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+		}
+	}
+
+	return info
+}
+
+// RestartInstance restarts an EC2 instance (stop then start). It always
+// waits for the instance to finish stopping before starting it back up:
+// real EC2 rejects StartInstances with IncorrectInstanceState for an
+// instance that is merely stopping, so a fire-and-forget stop here would
+// fail the exact case it's meant to help with.
+func (m *Manager) RestartInstance(ctx context.Context, instanceID string) error {
+	// First, stop the instance
+	if err := m.StopInstance(ctx, instanceID, false); err != nil {
+		return err
+	}
+
+	log.Printf("Waiting for instance %s to stop...", instanceID)
+	if err := m.waitForStopped(ctx, instanceID); err != nil {
+		return err
+	}
+
+	// Start the instance
+	log.Printf("Starting instance %s...", instanceID)
+	return m.StartInstance(ctx, instanceID, false)
+}
+
+// ErrCapacityReservationConflict is returned when an instance is targeted at
+// a specific capacity reservation that does not support the requested
+// instance type. Modifying the instance type in place would fail with
+// ReservationCapacityExceeded, so callers must reconcile the reservation
+// target before retrying.
+type ErrCapacityReservationConflict struct {
+	InstanceID            string
+	CapacityReservationID string
+	ReservedInstanceType  string
+	RequestedInstanceType string
+}
+
+func (e *ErrCapacityReservationConflict) Error() string {
+	return fmt.Sprintf(
+		"instance %s is targeted at capacity reservation %s for type %s, which does not support requested type %s",
+		e.InstanceID, e.CapacityReservationID, e.ReservedInstanceType, e.RequestedInstanceType,
+	)
+}
+
+// checkCapacityReservationCompatibility returns ErrCapacityReservationConflict
+// when spec targets a specific capacity reservation that was not created for
+// newInstanceType. An instance with no reservation, or one with an open
+// (non-targeted) reservation, is always safe to modify in place.
+func checkCapacityReservationCompatibility(ctx context.Context, client EC2API, instanceID, newInstanceType string, spec *types.CapacityReservationSpecificationResponse) error {
+	if spec == nil || spec.CapacityReservationTarget == nil {
+		return nil
+	}
+
+	reservationID := aws.ToString(spec.CapacityReservationTarget.CapacityReservationId)
+	if reservationID == "" {
+		return nil
+	}
+
+	describeResult, err := client.DescribeCapacityReservations(ctx, &ec2.DescribeCapacityReservationsInput{
+		CapacityReservationIds: []string{reservationID},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to describe capacity reservation %s: %w", reservationID, err)
+	}
+	if len(describeResult.CapacityReservations) == 0 {
+		return fmt.Errorf("capacity reservation %s not found", reservationID)
+	}
+
+	reservedType := aws.ToString(describeResult.CapacityReservations[0].InstanceType)
+	if reservedType != newInstanceType {
+		return &ErrCapacityReservationConflict{
+			InstanceID:            instanceID,
+			CapacityReservationID: reservationID,
+			ReservedInstanceType:  reservedType,
+			RequestedInstanceType: newInstanceType,
+		}
+	}
+
+	return nil
+}
+
+// ChangeInstanceTypeResult reports the side effects of a change_type action
+// beyond plain success/failure, so callers can surface them to the user.
+type ChangeInstanceTypeResult struct {
+	// SnapshotIDs lists any pre-change snapshots taken, in creation order.
+	SnapshotIDs []string
+	// RolledBack is true if the instance failed to start on the new type
+	// and was restored to its original instance type.
+	RolledBack bool
+}
+
+// ChangeInstanceType changes the instance type of an EC2 instance. If the
+// instance isn't already stopped, ChangeInstanceType always waits for it to
+// finish stopping before modifying it: ModifyInstanceAttribute for
+// instanceType requires the stopped state, so a fire-and-forget stop would
+// reliably fail with IncorrectInstanceState. When createSnapshot is true,
+// the instance's volumes are snapshotted before the modify; if the instance
+// then fails to start on the new type, its original instance type is
+// restored automatically.
+func (m *Manager) ChangeInstanceType(ctx context.Context, instanceID, newInstanceType string, createSnapshot bool) (*ChangeInstanceTypeResult, error) {
+	return m.changeInstanceType(ctx, m.client, instanceID, newInstanceType, createSnapshot)
+}
+
+// changeInstanceType holds the ChangeInstanceType logic against an EC2API so
+// it can be driven by a fake client in tests.
+func (m *Manager) changeInstanceType(ctx context.Context, client EC2API, instanceID, newInstanceType string, createSnapshot bool) (*ChangeInstanceTypeResult, error) {
+	result := &ChangeInstanceTypeResult{}
+
+	// Check current instance state
+	describeInput := &ec2.DescribeInstancesInput{
+		InstanceIds: []string{instanceID},
+	}
+
+	describeResult, err := client.DescribeInstances(ctx, describeInput)
+	if err != nil {
+		return result, fmt.Errorf("failed to describe instance: %w", err)
+	}
+
+	if len(describeResult.Reservations) == 0 || len(describeResult.Reservations[0].Instances) == 0 {
+		return result, fmt.Errorf("instance %s not found", instanceID)
+	}
+
+	instance := describeResult.Reservations[0].Instances[0]
+	currentState := instance.State.Name
+	originalInstanceType := string(instance.InstanceType)
+
+	// Instance must be stopped to change type
+	if currentState != types.InstanceStateNameStopped {
+		log.Printf("Instance %s is in state %s, stopping it first...", instanceID, currentState)
+		if err := m.StopInstance(ctx, instanceID, false); err != nil {
+			return result, err
+		}
+
+		if err := m.waitForStopped(ctx, instanceID); err != nil {
+			return result, err
+		}
+	}
+
+	if err := checkCapacityReservationCompatibility(ctx, client, instanceID, newInstanceType, instance.CapacityReservationSpecification); err != nil {
+		return result, err
+	}
+
+	if createSnapshot {
+		snapshotIDs, err := createPreChangeSnapshot(ctx, client, instanceID)
+		if err != nil {
+			return result, fmt.Errorf("failed to create pre-change snapshot: %w", err)
+		}
+		result.SnapshotIDs = snapshotIDs
+	}
+
+	// Modify instance type
+	modifyInput := &ec2.ModifyInstanceAttributeInput{
+		InstanceId: aws.String(instanceID),
+		InstanceType: &types.AttributeValue{
+			Value: aws.String(newInstanceType),
+		},
+	}
+
+	if _, err := client.ModifyInstanceAttribute(ctx, modifyInput); err != nil {
+		return result, fmt.Errorf("failed to modify instance type: %w", err)
+	}
+
+	if err := m.StartInstance(ctx, instanceID, false); err != nil {
+		if rollbackErr := rollbackInstanceType(ctx, client, instanceID, originalInstanceType); rollbackErr != nil {
+			return result, fmt.Errorf("instance failed to start on type %s (%v), and rollback to %s also failed: %w", newInstanceType, err, originalInstanceType, rollbackErr)
+		}
+		result.RolledBack = true
+		return result, fmt.Errorf("instance failed to start on type %s, rolled back to %s: %w", newInstanceType, originalInstanceType, err)
+	}
+
+	log.Printf("Successfully changed instance %s type to %s", instanceID, newInstanceType)
+	return result, nil
+}
+
+// createPreChangeSnapshot snapshots instanceID's volumes before a resize, so
+// the data remains recoverable even if the change itself can't be rolled
+// back cleanly.
+func createPreChangeSnapshot(ctx context.Context, client EC2API, instanceID string) ([]string, error) {
+	output, err := client.CreateSnapshots(ctx, &ec2.CreateSnapshotsInput{
+		InstanceSpecification: &types.InstanceSpecification{
+			InstanceId: aws.String(instanceID),
+		},
+		Description: aws.String(fmt.Sprintf("Pre-change_type snapshot for %s", instanceID)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	snapshotIDs := make([]string, 0, len(output.Snapshots))
+	for _, snapshot := range output.Snapshots {
+		snapshotIDs = append(snapshotIDs, aws.ToString(snapshot.SnapshotId))
+	}
+	return snapshotIDs, nil
+}
+
+// rollbackInstanceType restores instanceID to originalInstanceType after a
+// failed post-change start, so a resize that doesn't boot doesn't strand the
+// instance on the new type.
+func rollbackInstanceType(ctx context.Context, client EC2API, instanceID, originalInstanceType string) error {
+	_, err := client.ModifyInstanceAttribute(ctx, &ec2.ModifyInstanceAttributeInput{
+		InstanceId: aws.String(instanceID),
+		InstanceType: &types.AttributeValue{
+			Value: aws.String(originalInstanceType),
+		},
+	})
+	return err
+}
@@ -0,0 +1,522 @@
+package ec2manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// newTestManager returns a Manager backed by a fakeEC2Client seeded with a
+// single stopped instance, so Manager methods can be exercised without AWS
+// credentials.
+func newTestManager() *Manager {
+	return NewWithClient(newFakeEC2Client(types.Instance{
+		InstanceId:   aws.String("i-1234567890abcdef0"),
+		InstanceType: types.InstanceTypeT2Micro,
+		State:        &types.InstanceState{Name: types.InstanceStateNameStopped},
+	}))
+}
+
+func TestRunConcurrent(t *testing.T) {
+	instanceIDs := []string{"i-1", "i-2", "i-3", "i-4"}
+
+	var active int32
+	var maxActive int32
+	var mu sync.Mutex
+
+	results := RunConcurrent(instanceIDs, 2, func(instanceID string) (string, error) {
+		mu.Lock()
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		active--
+		mu.Unlock()
+
+		if instanceID == "i-3" {
+			return "", errors.New("boom")
+		}
+		return "ok: " + instanceID, nil
+	})
+
+	if len(results) != len(instanceIDs) {
+		t.Fatalf("expected %d results, got %d", len(instanceIDs), len(results))
+	}
+	if maxActive > 2 {
+		t.Errorf("expected concurrency to be capped at 2, saw %d concurrent workers", maxActive)
+	}
+
+	for i, result := range results {
+		if result.InstanceID != instanceIDs[i] {
+			t.Errorf("expected result %d to be for %s, got %s", i, instanceIDs[i], result.InstanceID)
+		}
+		if result.InstanceID == "i-3" {
+			if result.Success || result.Error == "" {
+				t.Errorf("expected i-3 to have failed with an error, got %+v", result)
+			}
+			continue
+		}
+		if !result.Success || result.Error != "" {
+			t.Errorf("expected %s to succeed, got %+v", result.InstanceID, result)
+		}
+	}
+}
+
+// fakeEC2Client is an in-memory EC2API double for exercising Manager without
+// AWS credentials.
+type fakeEC2Client struct {
+	mu             sync.Mutex
+	instances      map[string]types.Instance
+	reservations   map[string]types.CapacityReservation
+	startErr       error
+	stopErr        error
+	modifyErr      error
+	describeErr    error
+	reservationErr error
+	snapshotErr    error
+	nextSnapshotID int
+}
+
+func newFakeEC2Client(instances ...types.Instance) *fakeEC2Client {
+	client := &fakeEC2Client{
+		instances:    make(map[string]types.Instance),
+		reservations: make(map[string]types.CapacityReservation),
+	}
+	for _, instance := range instances {
+		client.instances[aws.ToString(instance.InstanceId)] = instance
+	}
+	return client
+}
+
+func (f *fakeEC2Client) withReservation(id string, reservation types.CapacityReservation) *fakeEC2Client {
+	f.reservations[id] = reservation
+	return f
+}
+
+func (f *fakeEC2Client) StartInstances(ctx context.Context, params *ec2.StartInstancesInput, optFns ...func(*ec2.Options)) (*ec2.StartInstancesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.startErr != nil {
+		return nil, f.startErr
+	}
+
+	var changes []types.InstanceStateChange
+	for _, id := range params.InstanceIds {
+		instance := f.instances[id]
+		previous := types.InstanceStateNamePending
+		if instance.State != nil {
+			previous = instance.State.Name
+		}
+		instance.State = &types.InstanceState{Name: types.InstanceStateNameRunning}
+		f.instances[id] = instance
+		changes = append(changes, types.InstanceStateChange{
+			InstanceId:    aws.String(id),
+			PreviousState: &types.InstanceState{Name: previous},
+			CurrentState:  &types.InstanceState{Name: types.InstanceStateNameRunning},
+		})
+	}
+	return &ec2.StartInstancesOutput{StartingInstances: changes}, nil
+}
+
+func (f *fakeEC2Client) StopInstances(ctx context.Context, params *ec2.StopInstancesInput, optFns ...func(*ec2.Options)) (*ec2.StopInstancesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.stopErr != nil {
+		return nil, f.stopErr
+	}
+
+	var changes []types.InstanceStateChange
+	for _, id := range params.InstanceIds {
+		instance := f.instances[id]
+		previous := types.InstanceStateNameRunning
+		if instance.State != nil {
+			previous = instance.State.Name
+		}
+		instance.State = &types.InstanceState{Name: types.InstanceStateNameStopped}
+		f.instances[id] = instance
+		changes = append(changes, types.InstanceStateChange{
+			InstanceId:    aws.String(id),
+			PreviousState: &types.InstanceState{Name: previous},
+			CurrentState:  &types.InstanceState{Name: types.InstanceStateNameStopped},
+		})
+	}
+	return &ec2.StopInstancesOutput{StoppingInstances: changes}, nil
+}
+
+func (f *fakeEC2Client) DescribeInstances(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.describeErr != nil {
+		return nil, f.describeErr
+	}
+
+	var matched []types.Instance
+	if len(params.InstanceIds) > 0 {
+		for _, id := range params.InstanceIds {
+			if instance, ok := f.instances[id]; ok {
+				matched = append(matched, instance)
+			}
+		}
+	} else {
+		for _, instance := range f.instances {
+			if instanceMatchesFilters(instance, params.Filters) {
+				matched = append(matched, instance)
+			}
+		}
+	}
+
+	if len(matched) == 0 {
+		return &ec2.DescribeInstancesOutput{}, nil
+	}
+	return &ec2.DescribeInstancesOutput{Reservations: []types.Reservation{{Instances: matched}}}, nil
+}
+
+func (f *fakeEC2Client) ModifyInstanceAttribute(ctx context.Context, params *ec2.ModifyInstanceAttributeInput, optFns ...func(*ec2.Options)) (*ec2.ModifyInstanceAttributeOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.modifyErr != nil {
+		return nil, f.modifyErr
+	}
+
+	id := aws.ToString(params.InstanceId)
+	instance := f.instances[id]
+	if params.InstanceType != nil && params.InstanceType.Value != nil {
+		instance.InstanceType = types.InstanceType(*params.InstanceType.Value)
+	}
+	f.instances[id] = instance
+	return &ec2.ModifyInstanceAttributeOutput{}, nil
+}
+
+func (f *fakeEC2Client) DescribeCapacityReservations(ctx context.Context, params *ec2.DescribeCapacityReservationsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeCapacityReservationsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.reservationErr != nil {
+		return nil, f.reservationErr
+	}
+
+	var found []types.CapacityReservation
+	for _, id := range params.CapacityReservationIds {
+		if reservation, ok := f.reservations[id]; ok {
+			found = append(found, reservation)
+		}
+	}
+	return &ec2.DescribeCapacityReservationsOutput{CapacityReservations: found}, nil
+}
+
+func (f *fakeEC2Client) CreateSnapshots(ctx context.Context, params *ec2.CreateSnapshotsInput, optFns ...func(*ec2.Options)) (*ec2.CreateSnapshotsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.snapshotErr != nil {
+		return nil, f.snapshotErr
+	}
+
+	f.nextSnapshotID++
+	return &ec2.CreateSnapshotsOutput{
+		Snapshots: []types.SnapshotInfo{
+			{SnapshotId: aws.String(fmt.Sprintf("snap-%d", f.nextSnapshotID))},
+		},
+	}, nil
+}
+
+// instanceMatchesFilters reports whether instance satisfies every tag:Key
+// filter in filters. It's the test-double counterpart of the real
+// DescribeInstances tag filtering used by InstancesByTag.
+func instanceMatchesFilters(instance types.Instance, filters []types.Filter) bool {
+	for _, filter := range filters {
+		name := aws.ToString(filter.Name)
+		if !strings.HasPrefix(name, "tag:") {
+			continue
+		}
+		key := strings.TrimPrefix(name, "tag:")
+
+		var tagValue string
+		var found bool
+		for _, tag := range instance.Tags {
+			if aws.ToString(tag.Key) == key {
+				tagValue = aws.ToString(tag.Value)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+
+		matched := false
+		for _, want := range filter.Values {
+			if want == tagValue {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+func TestChangeInstanceTypeCapacityReservation(t *testing.T) {
+	stoppedInstance := func(spec *types.CapacityReservationSpecificationResponse) types.Instance {
+		return types.Instance{
+			InstanceId:                       aws.String("i-1234567890abcdef0"),
+			State:                            &types.InstanceState{Name: types.InstanceStateNameStopped},
+			CapacityReservationSpecification: spec,
+		}
+	}
+
+	tests := []struct {
+		name          string
+		instance      types.Instance
+		reservationID string
+		reservation   types.CapacityReservation
+		wantErr       bool
+		wantConflict  bool
+	}{
+		{
+			name:     "no reservation",
+			instance: stoppedInstance(nil),
+		},
+		{
+			name: "open reservation",
+			instance: stoppedInstance(&types.CapacityReservationSpecificationResponse{
+				CapacityReservationPreference: types.CapacityReservationPreferenceOpen,
+			}),
+		},
+		{
+			name: "targeted reservation matching new type",
+			instance: stoppedInstance(&types.CapacityReservationSpecificationResponse{
+				CapacityReservationTarget: &types.CapacityReservationTargetResponse{
+					CapacityReservationId: aws.String("cr-matching"),
+				},
+			}),
+			reservationID: "cr-matching",
+			reservation:   types.CapacityReservation{InstanceType: aws.String("m5.large")},
+		},
+		{
+			name: "targeted reservation mismatching new type",
+			instance: stoppedInstance(&types.CapacityReservationSpecificationResponse{
+				CapacityReservationTarget: &types.CapacityReservationTargetResponse{
+					CapacityReservationId: aws.String("cr-mismatching"),
+				},
+			}),
+			reservationID: "cr-mismatching",
+			reservation:   types.CapacityReservation{InstanceType: aws.String("t3.small")},
+			wantErr:       true,
+			wantConflict:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeEC2Client(tt.instance)
+			if tt.reservationID != "" {
+				client.withReservation(tt.reservationID, tt.reservation)
+			}
+			manager := NewWithClient(client)
+
+			_, err := manager.changeInstanceType(context.Background(), client, "i-1234567890abcdef0", "m5.large", false)
+
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("expected an error, got none")
+			}
+			if tt.wantConflict {
+				var conflictErr *ErrCapacityReservationConflict
+				if !errors.As(err, &conflictErr) {
+					t.Fatalf("expected an ErrCapacityReservationConflict, got %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestChangeInstanceTypeCreatesSnapshotBeforeChange(t *testing.T) {
+	client := newFakeEC2Client(types.Instance{
+		InstanceId: aws.String("i-1234567890abcdef0"),
+		State:      &types.InstanceState{Name: types.InstanceStateNameStopped},
+	})
+	manager := NewWithClient(client)
+
+	result, err := manager.changeInstanceType(context.Background(), client, "i-1234567890abcdef0", "m5.large", true)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(result.SnapshotIDs) != 1 || result.SnapshotIDs[0] != "snap-1" {
+		t.Errorf("expected one recorded snapshot ID, got %v", result.SnapshotIDs)
+	}
+}
+
+func TestChangeInstanceTypeRollsBackOnFailedStart(t *testing.T) {
+	client := newFakeEC2Client(types.Instance{
+		InstanceId:   aws.String("i-1234567890abcdef0"),
+		InstanceType: types.InstanceTypeT2Micro,
+		State:        &types.InstanceState{Name: types.InstanceStateNameStopped},
+	})
+	client.startErr = errors.New("InsufficientInstanceCapacity")
+	manager := NewWithClient(client)
+
+	result, err := manager.changeInstanceType(context.Background(), client, "i-1234567890abcdef0", "m5.large", false)
+	if err == nil {
+		t.Fatal("expected an error when the instance fails to start, got none")
+	}
+	if !result.RolledBack {
+		t.Error("expected RolledBack to be true")
+	}
+
+	instance := client.instances["i-1234567890abcdef0"]
+	if instance.InstanceType != types.InstanceTypeT2Micro {
+		t.Errorf("expected instance type to be rolled back to %s, got %s", types.InstanceTypeT2Micro, instance.InstanceType)
+	}
+}
+
+func TestDescribeInstance(t *testing.T) {
+	launchTime := time.Date(2026, 1, 15, 9, 0, 0, 0, time.UTC)
+	client := newFakeEC2Client(types.Instance{
+		InstanceId:       aws.String("i-1234567890abcdef0"),
+		InstanceType:     types.InstanceTypeT3Medium,
+		State:            &types.InstanceState{Name: types.InstanceStateNameRunning},
+		PrivateIpAddress: aws.String("10.0.0.5"),
+		PublicIpAddress:  aws.String("203.0.113.10"),
+		RootDeviceType:   types.DeviceTypeEbs,
+		LaunchTime:       &launchTime,
+		Placement:        &types.Placement{AvailabilityZone: aws.String("us-east-1a")},
+		IamInstanceProfile: &types.IamInstanceProfile{
+			Arn: aws.String("arn:aws:iam::123456789012:instance-profile/example"),
+		},
+		Tags: []types.Tag{
+			{Key: aws.String("Name"), Value: aws.String("web-1")},
+		},
+	})
+
+	info, err := describeInstance(context.Background(), client, "i-1234567890abcdef0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if info.InstanceID != "i-1234567890abcdef0" {
+		t.Errorf("expected InstanceID i-1234567890abcdef0, got %s", info.InstanceID)
+	}
+	if info.State != "running" {
+		t.Errorf("expected State running, got %s", info.State)
+	}
+	if info.InstanceType != "t3.medium" {
+		t.Errorf("expected InstanceType t3.medium, got %s", info.InstanceType)
+	}
+	if info.AvailabilityZone != "us-east-1a" {
+		t.Errorf("expected AvailabilityZone us-east-1a, got %s", info.AvailabilityZone)
+	}
+	if !info.LaunchTime.Equal(launchTime) {
+		t.Errorf("expected LaunchTime %v, got %v", launchTime, info.LaunchTime)
+	}
+	if info.Tags["Name"] != "web-1" {
+		t.Errorf("expected tag Name=web-1, got %v", info.Tags)
+	}
+	if info.IAMInstanceProfile != "arn:aws:iam::123456789012:instance-profile/example" {
+		t.Errorf("expected IAM profile arn, got %s", info.IAMInstanceProfile)
+	}
+}
+
+func TestDescribeInstanceNotFound(t *testing.T) {
+	client := newFakeEC2Client()
+
+	if _, err := describeInstance(context.Background(), client, "i-missing"); err == nil {
+		t.Fatal("expected an error for a missing instance, got none")
+	}
+}
+
+// fakeInstanceStoppedWaiter records the maxWaitDur it was called with so
+// tests can assert on deadline-shrinking without real polling delays.
+type fakeInstanceStoppedWaiter struct {
+	gotMaxWait time.Duration
+	err        error
+}
+
+func (f *fakeInstanceStoppedWaiter) Wait(ctx context.Context, params *ec2.DescribeInstancesInput, maxWaitDur time.Duration, optFns ...func(*ec2.InstanceStoppedWaiterOptions)) error {
+	f.gotMaxWait = maxWaitDur
+	return f.err
+}
+
+func TestWaitForStoppedShrinksForDeadline(t *testing.T) {
+	waiter := &fakeInstanceStoppedWaiter{}
+	manager := newTestManager()
+	manager.SetWaitConfig(WaitConfig{
+		MaxWait:       5 * time.Minute,
+		MinDelay:      2 * time.Second,
+		MaxDelay:      30 * time.Second,
+		WaiterFactory: func(EC2API) InstanceStoppedWaiter { return waiter },
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if err := manager.waitForStopped(ctx, "i-1234567890abcdef0"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if waiter.gotMaxWait <= 0 || waiter.gotMaxWait >= 5*time.Minute {
+		t.Errorf("expected the wait window to shrink below the 5m default to fit the context deadline, got %s", waiter.gotMaxWait)
+	}
+}
+
+func TestWaitForStoppedDeadlineTooTight(t *testing.T) {
+	manager := newTestManager()
+	manager.SetWaitConfig(WaitConfig{
+		MaxWait:       5 * time.Minute,
+		WaiterFactory: func(EC2API) InstanceStoppedWaiter { return &fakeInstanceStoppedWaiter{} },
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := manager.waitForStopped(ctx, "i-1234567890abcdef0"); err == nil {
+		t.Fatal("expected an error when the deadline leaves no safety margin, got none")
+	}
+}
+
+func TestRestartInstanceAlwaysWaits(t *testing.T) {
+	client := newFakeEC2Client(types.Instance{
+		InstanceId: aws.String("i-1234567890abcdef0"),
+		State:      &types.InstanceState{Name: types.InstanceStateNameRunning},
+	})
+	manager := NewWithClient(client)
+
+	waiterCalled := false
+	manager.SetWaitConfig(WaitConfig{
+		WaiterFactory: func(EC2API) InstanceStoppedWaiter {
+			waiterCalled = true
+			return &fakeInstanceStoppedWaiter{}
+		},
+	})
+
+	if err := manager.RestartInstance(context.Background(), "i-1234567890abcdef0"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !waiterCalled {
+		t.Error("expected RestartInstance to always wait for the instance to stop")
+	}
+}
@@ -4,243 +4,296 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"time"
+	"strings"
+	"sync"
 
 	"github.com/aws/aws-lambda-go/lambda"
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/ec2"
-	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	"github.com/CraigDevJohnson/ec2_manager/internal/ec2manager"
 )
 
 // Request represents the incoming Lambda request
 type Request struct {
-	Action       string `json:"action"`                  // start, stop, restart, change_type
-	InstanceID   string `json:"instance_id"`             // EC2 instance ID
-	InstanceType string `json:"instance_type,omitempty"` // For change_type action
+	Action       string   `json:"action"`                  // start, stop, restart, change_type, status (alias: describe)
+	InstanceID   string   `json:"instance_id,omitempty"`   // single EC2 instance ID
+	InstanceIDs  []string `json:"instance_ids,omitempty"`  // multiple EC2 instance IDs for bulk actions
+	TagSelector  string   `json:"tag_selector,omitempty"`  // e.g. "tag:Environment=dev" to select instances by tag
+	InstanceType string   `json:"instance_type,omitempty"` // For change_type action
+	Concurrency  int      `json:"concurrency,omitempty"`   // max instances actioned concurrently (default defaultConcurrency)
+	// WaitFor controls whether a plain stop action blocks until the instance
+	// is confirmed stopped before returning. Defaults to false
+	// (fire-and-forget); restart and change_type always wait for the
+	// instance to finish stopping, since AWS rejects the start/modify call
+	// that follows until it does.
+	WaitFor *bool `json:"wait_for,omitempty"`
+	// CreateSnapshotBeforeChange snapshots the instance's volumes before a
+	// change_type action, so the data is recoverable if the resize fails.
+	CreateSnapshotBeforeChange bool `json:"create_snapshot_before_change,omitempty"`
 }
 
 // Response represents the Lambda response
 type Response struct {
-	Success bool   `json:"success"`
-	Message string `json:"message"`
-	Error   string `json:"error,omitempty"`
+	Success  bool                        `json:"success"`
+	Message  string                      `json:"message"`
+	Error    string                      `json:"error,omitempty"`
+	Results  []ec2manager.InstanceResult `json:"results,omitempty"`  // populated for bulk/tag-selector requests
+	Instance *ec2manager.InstanceInfo    `json:"instance,omitempty"` // populated for status/describe requests
+	// SnapshotIDs lists any pre-change snapshots taken for a single-instance
+	// change_type request with create_snapshot_before_change set.
+	SnapshotIDs []string `json:"snapshot_ids,omitempty"`
 }
 
-// EC2Manager handles EC2 operations
-type EC2Manager struct {
-	client *ec2.Client
+// waitForStop reports whether a plain stop action should block until the
+// instance is confirmed stopped before returning. Defaults to false.
+func (r Request) waitForStop() bool {
+	return r.WaitFor != nil && *r.WaitFor
 }
 
-// NewEC2Manager creates a new EC2Manager
-func NewEC2Manager(ctx context.Context) (*EC2Manager, error) {
-	cfg, err := config.LoadDefaultConfig(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("unable to load SDK config: %w", err)
-	}
-
-	return &EC2Manager{
-		client: ec2.NewFromConfig(cfg),
-	}, nil
-}
+// resolveInstanceIDs determines which instances a request targets: an
+// explicit instance_id, a list of instance_ids, or a tag_selector (e.g.
+// "tag:Environment=dev") resolved via the manager.
+func resolveInstanceIDs(ctx context.Context, manager *ec2manager.Manager, request Request) ([]string, error) {
+	if request.TagSelector != "" {
+		key, value, err := parseTagSelector(request.TagSelector)
+		if err != nil {
+			return nil, err
+		}
 
-// StartInstance starts an EC2 instance
-func (m *EC2Manager) StartInstance(ctx context.Context, instanceID string) error {
-	input := &ec2.StartInstancesInput{
-		InstanceIds: []string{instanceID},
+		ids, err := manager.InstancesByTag(ctx, key, value)
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) == 0 {
+			return nil, fmt.Errorf("no instances matched tag selector %q", request.TagSelector)
+		}
+		return ids, nil
 	}
 
-	result, err := m.client.StartInstances(ctx, input)
-	if err != nil {
-		return fmt.Errorf("failed to start instance: %w", err)
+	if len(request.InstanceIDs) > 0 {
+		return request.InstanceIDs, nil
 	}
 
-	if len(result.StartingInstances) > 0 {
-		log.Printf("Instance %s state changing from %s to %s",
-			instanceID,
-			result.StartingInstances[0].PreviousState.Name,
-			result.StartingInstances[0].CurrentState.Name)
+	if request.InstanceID != "" {
+		return []string{request.InstanceID}, nil
 	}
 
-	return nil
+	return nil, fmt.Errorf("instance_id is required")
 }
 
-// StopInstance stops an EC2 instance
-func (m *EC2Manager) StopInstance(ctx context.Context, instanceID string) error {
-	input := &ec2.StopInstancesInput{
-		InstanceIds: []string{instanceID},
-	}
-
-	result, err := m.client.StopInstances(ctx, input)
-	if err != nil {
-		return fmt.Errorf("failed to stop instance: %w", err)
-	}
-
-	if len(result.StoppingInstances) > 0 {
-		log.Printf("Instance %s state changing from %s to %s",
-			instanceID,
-			result.StoppingInstances[0].PreviousState.Name,
-			result.StoppingInstances[0].CurrentState.Name)
+// isValidAction reports whether action is one HandleRequest knows how to run.
+func isValidAction(action string) bool {
+	switch action {
+	case "start", "stop", "restart", "change_type", "status", "describe":
+		return true
+	default:
+		return false
 	}
-
-	return nil
 }
 
-// RestartInstance restarts an EC2 instance (stop then start)
-func (m *EC2Manager) RestartInstance(ctx context.Context, instanceID string) error {
-	// First, stop the instance
-	if err := m.StopInstance(ctx, instanceID); err != nil {
-		return err
+// parseTagSelector parses a selector of the form "tag:Key=Value" into its key and value.
+func parseTagSelector(selector string) (key, value string, err error) {
+	const prefix = "tag:"
+	if !strings.HasPrefix(selector, prefix) {
+		return "", "", fmt.Errorf("tag_selector must be of the form %q, got %q", prefix+"Key=Value", selector)
 	}
 
-	// Wait for instance to be stopped
-	log.Printf("Waiting for instance %s to stop...", instanceID)
-	waiter := ec2.NewInstanceStoppedWaiter(m.client)
-	maxWaitTime := 5 * time.Minute
-	if err := waiter.Wait(ctx, &ec2.DescribeInstancesInput{
-		InstanceIds: []string{instanceID},
-	}, maxWaitTime); err != nil {
-		return fmt.Errorf("error waiting for instance to stop: %w", err)
+	kv := strings.SplitN(strings.TrimPrefix(selector, prefix), "=", 2)
+	if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+		return "", "", fmt.Errorf("tag_selector must be of the form %q, got %q", prefix+"Key=Value", selector)
 	}
-
-	// Start the instance
-	log.Printf("Starting instance %s...", instanceID)
-	return m.StartInstance(ctx, instanceID)
+	return kv[0], kv[1], nil
 }
 
-// ChangeInstanceType changes the instance type of an EC2 instance
-func (m *EC2Manager) ChangeInstanceType(ctx context.Context, instanceID, newInstanceType string) error {
-	// Check current instance state
-	describeInput := &ec2.DescribeInstancesInput{
-		InstanceIds: []string{instanceID},
-	}
-
-	result, err := m.client.DescribeInstances(ctx, describeInput)
-	if err != nil {
-		return fmt.Errorf("failed to describe instance: %w", err)
-	}
-
-	if len(result.Reservations) == 0 || len(result.Reservations[0].Instances) == 0 {
-		return fmt.Errorf("instance %s not found", instanceID)
-	}
-
-	instance := result.Reservations[0].Instances[0]
-	currentState := instance.State.Name
-
-	// Instance must be stopped to change type
-	if currentState != types.InstanceStateNameStopped {
-		log.Printf("Instance %s is in state %s, stopping it first...", instanceID, currentState)
-		if err := m.StopInstance(ctx, instanceID); err != nil {
-			return err
+// validateRequest checks the request shape before any EC2 calls are made. It
+// returns a non-nil Response when the request should be rejected outright.
+func validateRequest(request Request) *Response {
+	if request.Action == "" {
+		return &Response{
+			Success: false,
+			Message: "Validation failed",
+			Error:   "action is required",
 		}
+	}
 
-		// Wait for instance to be stopped
-		waiter := ec2.NewInstanceStoppedWaiter(m.client)
-		maxWaitTime := 5 * time.Minute
-		if err := waiter.Wait(ctx, &ec2.DescribeInstancesInput{
-			InstanceIds: []string{instanceID},
-		}, maxWaitTime); err != nil {
-			return fmt.Errorf("error waiting for instance to stop: %w", err)
+	if !isValidAction(request.Action) {
+		return &Response{
+			Success: false,
+			Message: "Invalid action",
+			Error:   fmt.Sprintf("unknown action: %s. Valid actions are: start, stop, restart, change_type, status (alias: describe)", request.Action),
 		}
 	}
 
-	// Modify instance type
-	modifyInput := &ec2.ModifyInstanceAttributeInput{
-		InstanceId: aws.String(instanceID),
-		InstanceType: &types.AttributeValue{
-			Value: aws.String(newInstanceType),
-		},
+	if request.Action == "change_type" && request.InstanceType == "" {
+		return &Response{
+			Success: false,
+			Message: "Validation failed",
+			Error:   "instance_type is required for change_type action",
+		}
 	}
 
-	_, err = m.client.ModifyInstanceAttribute(ctx, modifyInput)
-	if err != nil {
-		return fmt.Errorf("failed to modify instance type: %w", err)
+	if request.InstanceID == "" && len(request.InstanceIDs) == 0 && request.TagSelector == "" {
+		return &Response{
+			Success: false,
+			Message: "Validation failed",
+			Error:   "instance_id is required",
+		}
 	}
 
-	log.Printf("Successfully changed instance %s type to %s", instanceID, newInstanceType)
 	return nil
 }
 
 // HandleRequest processes the Lambda request
 func HandleRequest(ctx context.Context, request Request) (Response, error) {
-	log.Printf("Received request: action=%s, instance_id=%s, instance_type=%s",
-		request.Action, request.InstanceID, request.InstanceType)
+	log.Printf("Received request: action=%s, instance_id=%s, instance_ids=%v, tag_selector=%s, instance_type=%s",
+		request.Action, request.InstanceID, request.InstanceIDs, request.TagSelector, request.InstanceType)
 
-	// Validate request
-	if request.InstanceID == "" {
-		return Response{
-			Success: false,
-			Message: "Validation failed",
-			Error:   "instance_id is required",
-		}, nil
+	if resp := validateRequest(request); resp != nil {
+		return *resp, nil
 	}
 
-	if request.Action == "" {
+	// Create EC2 manager
+	manager, err := ec2manager.New(ctx)
+	if err != nil {
 		return Response{
 			Success: false,
-			Message: "Validation failed",
-			Error:   "action is required",
+			Message: "Failed to initialize EC2 manager",
+			Error:   err.Error(),
 		}, nil
 	}
 
-	// Create EC2 manager
-	manager, err := NewEC2Manager(ctx)
+	return runAction(ctx, request, manager), nil
+}
+
+// runAction resolves the request's target instances and carries out its
+// action against manager. Split out from HandleRequest so tests can drive it
+// with a fake-backed ec2manager.Manager instead of a real AWS client.
+func runAction(ctx context.Context, request Request, manager *ec2manager.Manager) Response {
+	instanceIDs, err := resolveInstanceIDs(ctx, manager, request)
 	if err != nil {
 		return Response{
 			Success: false,
-			Message: "Failed to initialize EC2 manager",
+			Message: "Validation failed",
 			Error:   err.Error(),
-		}, nil
+		}
+	}
+
+	// status/describe returns a single instance's state and doesn't fan out
+	// like the other actions.
+	if request.Action == "status" || request.Action == "describe" {
+		if len(instanceIDs) != 1 {
+			return Response{
+				Success: false,
+				Message: "Validation failed",
+				Error:   "status/describe requires exactly one instance",
+			}
+		}
+
+		info, err := manager.DescribeInstance(ctx, instanceIDs[0])
+		if err != nil {
+			return Response{
+				Success: false,
+				Message: fmt.Sprintf("Failed to execute action: %s", request.Action),
+				Error:   err.Error(),
+			}
+		}
+
+		return Response{
+			Success:  true,
+			Message:  fmt.Sprintf("Instance %s described successfully", instanceIDs[0]),
+			Instance: info,
+		}
 	}
 
-	// Execute the requested action
-	var actionErr error
-	var message string
+	// changeTypeResults records each change_type instance's snapshot IDs so
+	// the single-instance response path can surface them; bulk responses
+	// carry them inline in each InstanceResult.Message instead.
+	var changeTypeMu sync.Mutex
+	changeTypeResults := make(map[string][]string)
 
+	// Build the per-instance action to fan out
+	var action func(instanceID string) (string, error)
 	switch request.Action {
 	case "start":
-		actionErr = manager.StartInstance(ctx, request.InstanceID)
-		message = fmt.Sprintf("Instance %s started successfully", request.InstanceID)
+		action = func(instanceID string) (string, error) {
+			if err := manager.StartInstance(ctx, instanceID, false); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("Instance %s started successfully", instanceID), nil
+		}
 
 	case "stop":
-		actionErr = manager.StopInstance(ctx, request.InstanceID)
-		message = fmt.Sprintf("Instance %s stopped successfully", request.InstanceID)
+		action = func(instanceID string) (string, error) {
+			if err := manager.StopInstance(ctx, instanceID, false); err != nil {
+				return "", err
+			}
+			if request.waitForStop() {
+				if err := manager.WaitForStopped(ctx, instanceID); err != nil {
+					return "", err
+				}
+			}
+			return fmt.Sprintf("Instance %s stopped successfully", instanceID), nil
+		}
 
 	case "restart":
-		actionErr = manager.RestartInstance(ctx, request.InstanceID)
-		message = fmt.Sprintf("Instance %s restarted successfully", request.InstanceID)
+		action = func(instanceID string) (string, error) {
+			if err := manager.RestartInstance(ctx, instanceID); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("Instance %s restarted successfully", instanceID), nil
+		}
 
 	case "change_type":
-		if request.InstanceType == "" {
+		action = func(instanceID string) (string, error) {
+			result, err := manager.ChangeInstanceType(ctx, instanceID, request.InstanceType, request.CreateSnapshotBeforeChange)
+			if err != nil {
+				return "", err
+			}
+
+			message := fmt.Sprintf("Instance %s type changed to %s successfully", instanceID, request.InstanceType)
+			if len(result.SnapshotIDs) > 0 {
+				message += fmt.Sprintf(" (snapshots: %s)", strings.Join(result.SnapshotIDs, ", "))
+				changeTypeMu.Lock()
+				changeTypeResults[instanceID] = result.SnapshotIDs
+				changeTypeMu.Unlock()
+			}
+			return message, nil
+		}
+	}
+
+	results := ec2manager.RunConcurrent(instanceIDs, request.Concurrency, action)
+
+	// Preserve the original single-instance response shape when only one
+	// instance was targeted, so existing callers are unaffected.
+	if len(results) == 1 {
+		result := results[0]
+		if !result.Success {
 			return Response{
 				Success: false,
-				Message: "Validation failed",
-				Error:   "instance_type is required for change_type action",
-			}, nil
+				Message: fmt.Sprintf("Failed to execute action: %s", request.Action),
+				Error:   result.Error,
+			}
 		}
-		actionErr = manager.ChangeInstanceType(ctx, request.InstanceID, request.InstanceType)
-		message = fmt.Sprintf("Instance %s type changed to %s successfully", request.InstanceID, request.InstanceType)
-
-	default:
 		return Response{
-			Success: false,
-			Message: "Invalid action",
-			Error:   fmt.Sprintf("unknown action: %s. Valid actions are: start, stop, restart, change_type", request.Action),
-		}, nil
+			Success:     true,
+			Message:     result.Message,
+			SnapshotIDs: changeTypeResults[result.InstanceID],
+		}
 	}
 
-	if actionErr != nil {
-		return Response{
-			Success: false,
-			Message: fmt.Sprintf("Failed to execute action: %s", request.Action),
-			Error:   actionErr.Error(),
-		}, nil
+	succeeded, failed := 0, 0
+	for _, result := range results {
+		if result.Success {
+			succeeded++
+		} else {
+			failed++
+		}
 	}
 
 	return Response{
-		Success: true,
-		Message: message,
-	}, nil
+		Success: failed == 0,
+		Message: fmt.Sprintf("Actioned %d instance(s): %d succeeded, %d failed", len(results), succeeded, failed),
+		Results: results,
+	}
 }
 
 func main() {
@@ -2,16 +2,161 @@ package main
 
 import (
 	"context"
-	"os"
+	"strings"
+	"sync"
 	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	"github.com/CraigDevJohnson/ec2_manager/internal/ec2manager"
 )
 
-func TestRequestValidation(t *testing.T) {
-	// Skip tests that require AWS credentials in CI/CD
-	if os.Getenv("AWS_REGION") == "" {
-		t.Skip("Skipping tests that require AWS credentials")
+// fakeEC2Client is an in-memory ec2manager.EC2API double for exercising
+// HandleRequest/runAction without AWS credentials.
+type fakeEC2Client struct {
+	mu        sync.Mutex
+	instances map[string]types.Instance
+}
+
+func newFakeEC2Client(instances ...types.Instance) *fakeEC2Client {
+	client := &fakeEC2Client{instances: make(map[string]types.Instance)}
+	for _, instance := range instances {
+		client.instances[aws.ToString(instance.InstanceId)] = instance
+	}
+	return client
+}
+
+func (f *fakeEC2Client) StartInstances(ctx context.Context, params *ec2.StartInstancesInput, optFns ...func(*ec2.Options)) (*ec2.StartInstancesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, id := range params.InstanceIds {
+		instance := f.instances[id]
+		instance.State = &types.InstanceState{Name: types.InstanceStateNameRunning}
+		f.instances[id] = instance
+	}
+	return &ec2.StartInstancesOutput{}, nil
+}
+
+func (f *fakeEC2Client) StopInstances(ctx context.Context, params *ec2.StopInstancesInput, optFns ...func(*ec2.Options)) (*ec2.StopInstancesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, id := range params.InstanceIds {
+		instance := f.instances[id]
+		instance.State = &types.InstanceState{Name: types.InstanceStateNameStopped}
+		f.instances[id] = instance
+	}
+	return &ec2.StopInstancesOutput{}, nil
+}
+
+func (f *fakeEC2Client) DescribeInstances(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var matched []types.Instance
+	if len(params.InstanceIds) > 0 {
+		for _, id := range params.InstanceIds {
+			if instance, ok := f.instances[id]; ok {
+				matched = append(matched, instance)
+			}
+		}
+	} else {
+		for _, instance := range f.instances {
+			if instanceMatchesFilters(instance, params.Filters) {
+				matched = append(matched, instance)
+			}
+		}
+	}
+
+	if len(matched) == 0 {
+		return &ec2.DescribeInstancesOutput{}, nil
+	}
+	return &ec2.DescribeInstancesOutput{Reservations: []types.Reservation{{Instances: matched}}}, nil
+}
+
+func (f *fakeEC2Client) ModifyInstanceAttribute(ctx context.Context, params *ec2.ModifyInstanceAttributeInput, optFns ...func(*ec2.Options)) (*ec2.ModifyInstanceAttributeOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	id := aws.ToString(params.InstanceId)
+	instance := f.instances[id]
+	if params.InstanceType != nil && params.InstanceType.Value != nil {
+		instance.InstanceType = types.InstanceType(*params.InstanceType.Value)
 	}
+	f.instances[id] = instance
+	return &ec2.ModifyInstanceAttributeOutput{}, nil
+}
+
+func (f *fakeEC2Client) DescribeCapacityReservations(ctx context.Context, params *ec2.DescribeCapacityReservationsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeCapacityReservationsOutput, error) {
+	return &ec2.DescribeCapacityReservationsOutput{}, nil
+}
 
+func (f *fakeEC2Client) CreateSnapshots(ctx context.Context, params *ec2.CreateSnapshotsInput, optFns ...func(*ec2.Options)) (*ec2.CreateSnapshotsOutput, error) {
+	return &ec2.CreateSnapshotsOutput{}, nil
+}
+
+// instanceMatchesFilters reports whether instance satisfies every tag:Key
+// filter in filters. It's the test-double counterpart of the real
+// DescribeInstances tag filtering used by resolveInstanceIDs.
+func instanceMatchesFilters(instance types.Instance, filters []types.Filter) bool {
+	for _, filter := range filters {
+		name := aws.ToString(filter.Name)
+		if !strings.HasPrefix(name, "tag:") {
+			continue
+		}
+		key := strings.TrimPrefix(name, "tag:")
+
+		var tagValue string
+		var found bool
+		for _, tag := range instance.Tags {
+			if aws.ToString(tag.Key) == key {
+				tagValue = aws.ToString(tag.Value)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+
+		matched := false
+		for _, want := range filter.Values {
+			if want == tagValue {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// newTestManager returns a Manager backed by a fake EC2 client seeded with a
+// single stopped instance, so request handling can be exercised end to end
+// without AWS credentials.
+func newTestManager() *ec2manager.Manager {
+	return ec2manager.NewWithClient(newFakeEC2Client(types.Instance{
+		InstanceId:   aws.String("i-1234567890abcdef0"),
+		InstanceType: types.InstanceTypeT2Micro,
+		State:        &types.InstanceState{Name: types.InstanceStateNameStopped},
+	}))
+}
+
+// handleRequestForTest mirrors HandleRequest but takes an already-constructed
+// manager, so tests can supply a fake client instead of talking to AWS.
+func handleRequestForTest(ctx context.Context, request Request, manager *ec2manager.Manager) Response {
+	if resp := validateRequest(request); resp != nil {
+		return *resp
+	}
+	return runAction(ctx, request, manager)
+}
+
+func TestRequestValidation(t *testing.T) {
 	tests := []struct {
 		name        string
 		request     Request
@@ -91,11 +236,7 @@ func TestRequestValidation(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			ctx := context.Background()
 
-			response, err := HandleRequest(ctx, tt.request)
-
-			if err != nil {
-				t.Fatalf("HandleRequest returned unexpected error: %v", err)
-			}
+			response := handleRequestForTest(ctx, tt.request, newTestManager())
 
 			if tt.expectError {
 				if response.Success {
@@ -110,11 +251,81 @@ func TestRequestValidation(t *testing.T) {
 						t.Errorf("Expected error containing '%s', got '%s'", tt.errorMsg, response.Error)
 					}
 				}
+				return
+			}
+
+			if !response.Success {
+				t.Errorf("Expected success, got error: %s", response.Error)
 			}
 		})
 	}
 }
 
+func TestHandleRequestBulkTagSelector(t *testing.T) {
+	client := newFakeEC2Client(
+		types.Instance{
+			InstanceId: aws.String("i-dev-1"),
+			State:      &types.InstanceState{Name: types.InstanceStateNameRunning},
+			Tags:       []types.Tag{{Key: aws.String("Environment"), Value: aws.String("dev")}},
+		},
+		types.Instance{
+			InstanceId: aws.String("i-dev-2"),
+			State:      &types.InstanceState{Name: types.InstanceStateNameRunning},
+			Tags:       []types.Tag{{Key: aws.String("Environment"), Value: aws.String("dev")}},
+		},
+		types.Instance{
+			InstanceId: aws.String("i-prod-1"),
+			State:      &types.InstanceState{Name: types.InstanceStateNameRunning},
+			Tags:       []types.Tag{{Key: aws.String("Environment"), Value: aws.String("prod")}},
+		},
+	)
+	manager := ec2manager.NewWithClient(client)
+
+	response := handleRequestForTest(context.Background(), Request{
+		Action:      "stop",
+		TagSelector: "tag:Environment=dev",
+	}, manager)
+
+	if !response.Success {
+		t.Fatalf("expected success, got error: %s", response.Error)
+	}
+	if len(response.Results) != 2 {
+		t.Fatalf("expected 2 results, got %d: %+v", len(response.Results), response.Results)
+	}
+	for _, result := range response.Results {
+		if !result.Success {
+			t.Errorf("expected %s to succeed, got %+v", result.InstanceID, result)
+		}
+	}
+	if client.instances["i-prod-1"].State.Name != types.InstanceStateNameRunning {
+		t.Errorf("expected untargeted instance to be left running")
+	}
+}
+
+func TestHandleRequestStatus(t *testing.T) {
+	client := newFakeEC2Client(types.Instance{
+		InstanceId:   aws.String("i-1234567890abcdef0"),
+		InstanceType: types.InstanceTypeT3Micro,
+		State:        &types.InstanceState{Name: types.InstanceStateNameRunning},
+	})
+	manager := ec2manager.NewWithClient(client)
+
+	response := handleRequestForTest(context.Background(), Request{
+		Action:     "status",
+		InstanceID: "i-1234567890abcdef0",
+	}, manager)
+
+	if !response.Success {
+		t.Fatalf("expected success, got error: %s", response.Error)
+	}
+	if response.Instance == nil {
+		t.Fatal("expected Instance to be populated")
+	}
+	if response.Instance.State != "running" {
+		t.Errorf("expected state running, got %s", response.Instance.State)
+	}
+}
+
 // TestValidationOnly tests only the validation logic without AWS SDK
 func TestValidationOnly(t *testing.T) {
 	tests := []struct {
@@ -188,7 +399,7 @@ func TestValidationOnly(t *testing.T) {
 			} else if tt.request.Action == "" {
 				valid = false
 				errorMsg = "action is required"
-			} else if tt.request.Action != "start" && tt.request.Action != "stop" && 
+			} else if tt.request.Action != "start" && tt.request.Action != "stop" &&
 				tt.request.Action != "restart" && tt.request.Action != "change_type" {
 				valid = false
 				errorMsg = "unknown action"
@@ -223,9 +434,6 @@ func TestResponseStructure(t *testing.T) {
 	response := Response{
 		Success: true,
 		Message: "Operation successful",
-		Headers: map[string]string{
-			"Content-Type": "application/json",
-		},
 	}
 
 	if !response.Success {
@@ -239,10 +447,6 @@ func TestResponseStructure(t *testing.T) {
 	if response.Error != "" {
 		t.Errorf("Expected Error to be empty, got '%s'", response.Error)
 	}
-
-	if response.Headers == nil {
-		t.Errorf("Expected Headers to be initialized")
-	}
 }
 
 func TestRequestStructure(t *testing.T) {
@@ -265,26 +469,77 @@ func TestRequestStructure(t *testing.T) {
 	}
 }
 
-func TestCORSHeaders(t *testing.T) {
-	response := Response{
-		Success: true,
-		Message: "Test message",
-		Headers: map[string]string{
-			"Access-Control-Allow-Origin":  "*",
-			"Access-Control-Allow-Methods": "POST, OPTIONS",
-			"Access-Control-Allow-Headers": "Content-Type",
+func TestParseTagSelector(t *testing.T) {
+	tests := []struct {
+		name      string
+		selector  string
+		wantKey   string
+		wantValue string
+		expectErr bool
+	}{
+		{
+			name:      "valid selector",
+			selector:  "tag:Environment=dev",
+			wantKey:   "Environment",
+			wantValue: "dev",
+		},
+		{
+			name:      "missing tag prefix",
+			selector:  "Environment=dev",
+			expectErr: true,
+		},
+		{
+			name:      "missing value",
+			selector:  "tag:Environment=",
+			expectErr: true,
+		},
+		{
+			name:      "missing equals",
+			selector:  "tag:Environment",
+			expectErr: true,
 		},
 	}
 
-	if response.Headers["Access-Control-Allow-Origin"] != "*" {
-		t.Errorf("Expected CORS origin header to be '*', got '%s'", response.Headers["Access-Control-Allow-Origin"])
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, value, err := parseTagSelector(tt.selector)
+
+			if tt.expectErr {
+				if err == nil {
+					t.Fatalf("expected an error for selector %q, got none", tt.selector)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error for selector %q: %v", tt.selector, err)
+			}
+			if key != tt.wantKey || value != tt.wantValue {
+				t.Errorf("parseTagSelector(%q) = (%q, %q), want (%q, %q)", tt.selector, key, value, tt.wantKey, tt.wantValue)
+			}
+		})
 	}
+}
+
+func TestRequestWaitForStop(t *testing.T) {
+	enabled := true
+	disabled := false
 
-	if response.Headers["Access-Control-Allow-Methods"] != "POST, OPTIONS" {
-		t.Errorf("Expected CORS methods header to be 'POST, OPTIONS', got '%s'", response.Headers["Access-Control-Allow-Methods"])
+	tests := []struct {
+		name    string
+		request Request
+		want    bool
+	}{
+		{name: "unset defaults to false", request: Request{}, want: false},
+		{name: "explicit true", request: Request{WaitFor: &enabled}, want: true},
+		{name: "explicit false", request: Request{WaitFor: &disabled}, want: false},
 	}
 
-	if response.Headers["Access-Control-Allow-Headers"] != "Content-Type" {
-		t.Errorf("Expected CORS headers to be 'Content-Type', got '%s'", response.Headers["Access-Control-Allow-Headers"])
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.request.waitForStop(); got != tt.want {
+				t.Errorf("waitForStop() = %v, want %v", got, tt.want)
+			}
+		})
 	}
 }
@@ -0,0 +1,259 @@
+// Package scheduler applies tag-based start/stop policies to EC2 instances,
+// driven by an EventBridge scheduled rule instead of a direct Lambda
+// invocation. Operators opt an instance in by tagging it with a cron-like
+// schedule, e.g. AutoStart=mon-fri:08:00 and AutoStop=mon-fri:19:00.
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	"github.com/CraigDevJohnson/ec2_manager/internal/ec2manager"
+)
+
+// autoStartTag and autoStopTag are the instance tags operators set to opt an
+// instance into scheduled start/stop.
+const (
+	autoStartTag = "AutoStart"
+	autoStopTag  = "AutoStop"
+)
+
+// Scheduler applies AutoStart/AutoStop tag policies to EC2 instances,
+// driving start/stop through ec2manager.Manager so both Lambdas share the
+// same EC2 action logic.
+type Scheduler struct {
+	client  ec2manager.EC2API
+	manager *ec2manager.Manager
+}
+
+// New creates a Scheduler backed by client.
+func New(client ec2manager.EC2API) *Scheduler {
+	return &Scheduler{client: client, manager: ec2manager.NewWithClient(client)}
+}
+
+// InstanceResult captures the outcome of evaluating one instance's policy.
+type InstanceResult struct {
+	InstanceID string `json:"instance_id"`
+	Action     string `json:"action"` // "start", "stop", or "skip"
+	Success    bool   `json:"success"`
+	Message    string `json:"message,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Response summarizes one scheduler sweep.
+type Response struct {
+	Started int              `json:"started"`
+	Stopped int              `json:"stopped"`
+	Skipped int              `json:"skipped"`
+	Errored int              `json:"errored"`
+	DryRun  bool             `json:"dry_run"`
+	Results []InstanceResult `json:"results,omitempty"`
+}
+
+// Run evaluates AutoStart/AutoStop policies against now for every instance
+// tagged with either, starting/stopping instances that are due and not
+// already in the desired state. When dryRun is true, EC2 is called with
+// DryRun: true and Response reports what would have happened instead of
+// changing anything.
+func (s *Scheduler) Run(ctx context.Context, now time.Time, dryRun bool) (Response, error) {
+	describeResult, err := s.client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+		Filters: []types.Filter{
+			{Name: aws.String("tag-key"), Values: []string{autoStartTag, autoStopTag}},
+		},
+	})
+	if err != nil {
+		return Response{}, fmt.Errorf("failed to list scheduled instances: %w", err)
+	}
+
+	resp := Response{DryRun: dryRun}
+	for _, reservation := range describeResult.Reservations {
+		for _, instance := range reservation.Instances {
+			result := s.evaluate(ctx, instance, now, dryRun)
+			resp.Results = append(resp.Results, result)
+
+			switch {
+			case !result.Success:
+				resp.Errored++
+			case result.Action == "start":
+				resp.Started++
+			case result.Action == "stop":
+				resp.Stopped++
+			default:
+				resp.Skipped++
+			}
+		}
+	}
+
+	return resp, nil
+}
+
+// evaluate decides, and unless dryRun carries out, the action for a single
+// instance based on its AutoStart/AutoStop tags. AutoStart is checked
+// before AutoStop, so an instance tagged with both only takes one action
+// per sweep.
+func (s *Scheduler) evaluate(ctx context.Context, instance types.Instance, now time.Time, dryRun bool) InstanceResult {
+	instanceID := aws.ToString(instance.InstanceId)
+	state := types.InstanceStateNamePending
+	if instance.State != nil {
+		state = instance.State.Name
+	}
+
+	tags := make(map[string]string, len(instance.Tags))
+	for _, tag := range instance.Tags {
+		tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+
+	if value, ok := tags[autoStartTag]; ok {
+		sched, err := parseSchedule(value)
+		if err != nil {
+			return InstanceResult{InstanceID: instanceID, Action: "skip", Error: fmt.Sprintf("invalid %s tag: %v", autoStartTag, err)}
+		}
+		if sched.matches(now) {
+			if state == types.InstanceStateNameRunning {
+				return InstanceResult{InstanceID: instanceID, Action: "skip", Success: true, Message: "already running"}
+			}
+			return s.act(ctx, instanceID, "start", dryRun)
+		}
+	}
+
+	if value, ok := tags[autoStopTag]; ok {
+		sched, err := parseSchedule(value)
+		if err != nil {
+			return InstanceResult{InstanceID: instanceID, Action: "skip", Error: fmt.Sprintf("invalid %s tag: %v", autoStopTag, err)}
+		}
+		if sched.matches(now) {
+			if state == types.InstanceStateNameStopped {
+				return InstanceResult{InstanceID: instanceID, Action: "skip", Success: true, Message: "already stopped"}
+			}
+			return s.act(ctx, instanceID, "stop", dryRun)
+		}
+	}
+
+	return InstanceResult{InstanceID: instanceID, Action: "skip", Success: true, Message: "no policy due"}
+}
+
+// act starts or stops instanceID via the shared ec2manager.Manager. In
+// dry-run mode the manager calls EC2 with DryRun: true, which AWS answers
+// with a DryRunOperation error instead of taking action, and act reports
+// what would have happened.
+func (s *Scheduler) act(ctx context.Context, instanceID, action string, dryRun bool) InstanceResult {
+	result := InstanceResult{InstanceID: instanceID, Action: action}
+
+	var err error
+	switch action {
+	case "start":
+		err = s.manager.StartInstance(ctx, instanceID, dryRun)
+	case "stop":
+		err = s.manager.StopInstance(ctx, instanceID, dryRun)
+	}
+
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Success = true
+	switch {
+	case dryRun:
+		result.Message = fmt.Sprintf("dry run: would %s instance", action)
+	case action == "start":
+		result.Message = "instance started successfully"
+	default:
+		result.Message = "instance stopped successfully"
+	}
+	return result
+}
+
+// schedule is a parsed AutoStart/AutoStop tag value, e.g. "mon-fri:08:00".
+type schedule struct {
+	days         [7]bool
+	hour, minute int
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// parseSchedule parses a cron-like tag value of the form "<days>:<HH>:<MM>",
+// where <days> is "*", a single day ("mon"), or an inclusive range
+// ("mon-fri").
+func parseSchedule(value string) (schedule, error) {
+	var s schedule
+
+	parts := strings.SplitN(value, ":", 3)
+	if len(parts) != 3 {
+		return s, fmt.Errorf("schedule %q must be of the form \"<days>:<HH>:<MM>\"", value)
+	}
+
+	if err := s.setDays(parts[0]); err != nil {
+		return s, err
+	}
+
+	hour, err := strconv.Atoi(parts[1])
+	if err != nil || hour < 0 || hour > 23 {
+		return s, fmt.Errorf("schedule %q has an invalid hour", value)
+	}
+	minute, err := strconv.Atoi(parts[2])
+	if err != nil || minute < 0 || minute > 59 {
+		return s, fmt.Errorf("schedule %q has an invalid minute", value)
+	}
+	s.hour, s.minute = hour, minute
+
+	return s, nil
+}
+
+func (s *schedule) setDays(value string) error {
+	if value == "*" {
+		for i := range s.days {
+			s.days[i] = true
+		}
+		return nil
+	}
+
+	if before, after, found := strings.Cut(value, "-"); found {
+		startIdx, err := weekdayIndex(before)
+		if err != nil {
+			return err
+		}
+		endIdx, err := weekdayIndex(after)
+		if err != nil {
+			return err
+		}
+		for i := startIdx; ; i = (i + 1) % 7 {
+			s.days[i] = true
+			if i == endIdx {
+				break
+			}
+		}
+		return nil
+	}
+
+	idx, err := weekdayIndex(value)
+	if err != nil {
+		return err
+	}
+	s.days[idx] = true
+	return nil
+}
+
+func weekdayIndex(name string) (int, error) {
+	day, ok := weekdayNames[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unrecognized day %q", name)
+	}
+	return int(day), nil
+}
+
+// matches reports whether now falls on one of the schedule's days at its
+// exact hour and minute.
+func (s schedule) matches(now time.Time) bool {
+	return s.days[int(now.Weekday())] && now.Hour() == s.hour && now.Minute() == s.minute
+}
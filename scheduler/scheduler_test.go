@@ -0,0 +1,227 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+)
+
+// fakeClient is a minimal EC2API test double, in the same style as the
+// fakeEC2Client used for the root package's tests.
+type fakeClient struct {
+	instances map[string]types.Instance
+	startErr  error
+	stopErr   error
+}
+
+func newFakeClient(instances ...types.Instance) *fakeClient {
+	client := &fakeClient{instances: make(map[string]types.Instance)}
+	for _, instance := range instances {
+		client.instances[aws.ToString(instance.InstanceId)] = instance
+	}
+	return client
+}
+
+func (f *fakeClient) DescribeInstances(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+	var instances []types.Instance
+	for _, instance := range f.instances {
+		instances = append(instances, instance)
+	}
+	return &ec2.DescribeInstancesOutput{Reservations: []types.Reservation{{Instances: instances}}}, nil
+}
+
+func (f *fakeClient) StartInstances(ctx context.Context, params *ec2.StartInstancesInput, optFns ...func(*ec2.Options)) (*ec2.StartInstancesOutput, error) {
+	if aws.ToBool(params.DryRun) {
+		return nil, &smithy.GenericAPIError{Code: "DryRunOperation", Message: "would have started"}
+	}
+	if f.startErr != nil {
+		return nil, f.startErr
+	}
+	for _, id := range params.InstanceIds {
+		instance := f.instances[id]
+		instance.State = &types.InstanceState{Name: types.InstanceStateNameRunning}
+		f.instances[id] = instance
+	}
+	return &ec2.StartInstancesOutput{}, nil
+}
+
+func (f *fakeClient) StopInstances(ctx context.Context, params *ec2.StopInstancesInput, optFns ...func(*ec2.Options)) (*ec2.StopInstancesOutput, error) {
+	if aws.ToBool(params.DryRun) {
+		return nil, &smithy.GenericAPIError{Code: "DryRunOperation", Message: "would have stopped"}
+	}
+	if f.stopErr != nil {
+		return nil, f.stopErr
+	}
+	for _, id := range params.InstanceIds {
+		instance := f.instances[id]
+		instance.State = &types.InstanceState{Name: types.InstanceStateNameStopped}
+		f.instances[id] = instance
+	}
+	return &ec2.StopInstancesOutput{}, nil
+}
+
+// ModifyInstanceAttribute, DescribeCapacityReservations, and CreateSnapshots
+// round out ec2manager.EC2API; the scheduler doesn't exercise them, so these
+// are unused stubs to satisfy the interface.
+func (f *fakeClient) ModifyInstanceAttribute(ctx context.Context, params *ec2.ModifyInstanceAttributeInput, optFns ...func(*ec2.Options)) (*ec2.ModifyInstanceAttributeOutput, error) {
+	return &ec2.ModifyInstanceAttributeOutput{}, nil
+}
+
+func (f *fakeClient) DescribeCapacityReservations(ctx context.Context, params *ec2.DescribeCapacityReservationsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeCapacityReservationsOutput, error) {
+	return &ec2.DescribeCapacityReservationsOutput{}, nil
+}
+
+func (f *fakeClient) CreateSnapshots(ctx context.Context, params *ec2.CreateSnapshotsInput, optFns ...func(*ec2.Options)) (*ec2.CreateSnapshotsOutput, error) {
+	return &ec2.CreateSnapshotsOutput{}, nil
+}
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{name: "day range", value: "mon-fri:08:00"},
+		{name: "single day", value: "sat:09:30"},
+		{name: "wildcard day", value: "*:19:00"},
+		{name: "missing parts", value: "mon-fri:08", wantErr: true},
+		{name: "bad day", value: "funday:08:00", wantErr: true},
+		{name: "bad hour", value: "mon-fri:24:00", wantErr: true},
+		{name: "bad minute", value: "mon-fri:08:60", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseSchedule(tt.value)
+			if tt.wantErr && err == nil {
+				t.Fatal("expected an error, got none")
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestScheduleMatches(t *testing.T) {
+	sched, err := parseSchedule("mon-fri:08:00")
+	if err != nil {
+		t.Fatalf("failed to parse schedule: %v", err)
+	}
+
+	monday8am := time.Date(2026, 7, 27, 8, 0, 0, 0, time.UTC) // a Monday
+	if !sched.matches(monday8am) {
+		t.Error("expected Monday 08:00 to match mon-fri:08:00")
+	}
+
+	saturday8am := monday8am.AddDate(0, 0, 5)
+	if sched.matches(saturday8am) {
+		t.Error("expected Saturday 08:00 not to match mon-fri:08:00")
+	}
+
+	monday9am := monday8am.Add(time.Hour)
+	if sched.matches(monday9am) {
+		t.Error("expected Monday 09:00 not to match mon-fri:08:00")
+	}
+}
+
+func TestSchedulerRun(t *testing.T) {
+	now := time.Date(2026, 7, 27, 8, 0, 0, 0, time.UTC) // a Monday
+
+	client := newFakeClient(
+		types.Instance{
+			InstanceId: aws.String("i-start-due"),
+			State:      &types.InstanceState{Name: types.InstanceStateNameStopped},
+			Tags:       []types.Tag{{Key: aws.String(autoStartTag), Value: aws.String("mon-fri:08:00")}},
+		},
+		types.Instance{
+			InstanceId: aws.String("i-already-running"),
+			State:      &types.InstanceState{Name: types.InstanceStateNameRunning},
+			Tags:       []types.Tag{{Key: aws.String(autoStartTag), Value: aws.String("mon-fri:08:00")}},
+		},
+		types.Instance{
+			InstanceId: aws.String("i-stop-due"),
+			State:      &types.InstanceState{Name: types.InstanceStateNameRunning},
+			Tags:       []types.Tag{{Key: aws.String(autoStopTag), Value: aws.String("mon-fri:08:00")}},
+		},
+		types.Instance{
+			InstanceId: aws.String("i-not-due"),
+			State:      &types.InstanceState{Name: types.InstanceStateNameStopped},
+			Tags:       []types.Tag{{Key: aws.String(autoStartTag), Value: aws.String("mon-fri:19:00")}},
+		},
+		types.Instance{
+			InstanceId: aws.String("i-bad-tag"),
+			State:      &types.InstanceState{Name: types.InstanceStateNameStopped},
+			Tags:       []types.Tag{{Key: aws.String(autoStartTag), Value: aws.String("not-a-schedule")}},
+		},
+	)
+
+	scheduler := New(client)
+	resp, err := scheduler.Run(context.Background(), now, false)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if resp.Started != 1 || resp.Stopped != 1 || resp.Skipped != 2 || resp.Errored != 1 {
+		t.Errorf("expected 1 started, 1 stopped, 2 skipped, 1 errored, got %+v", resp)
+	}
+
+	if got := client.instances["i-start-due"].State.Name; got != types.InstanceStateNameRunning {
+		t.Errorf("expected i-start-due to be running, got %s", got)
+	}
+	if got := client.instances["i-stop-due"].State.Name; got != types.InstanceStateNameStopped {
+		t.Errorf("expected i-stop-due to be stopped, got %s", got)
+	}
+}
+
+func TestSchedulerRunDryRun(t *testing.T) {
+	now := time.Date(2026, 7, 27, 8, 0, 0, 0, time.UTC) // a Monday
+
+	client := newFakeClient(types.Instance{
+		InstanceId: aws.String("i-start-due"),
+		State:      &types.InstanceState{Name: types.InstanceStateNameStopped},
+		Tags:       []types.Tag{{Key: aws.String(autoStartTag), Value: aws.String("mon-fri:08:00")}},
+	})
+
+	scheduler := New(client)
+	resp, err := scheduler.Run(context.Background(), now, true)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if !resp.DryRun {
+		t.Error("expected Response.DryRun to be true")
+	}
+	if resp.Started != 1 {
+		t.Errorf("expected 1 (dry-run) start, got %+v", resp)
+	}
+	if got := client.instances["i-start-due"].State.Name; got != types.InstanceStateNameStopped {
+		t.Errorf("expected dry run not to change instance state, got %s", got)
+	}
+}
+
+func TestSchedulerRunActOnError(t *testing.T) {
+	now := time.Date(2026, 7, 27, 8, 0, 0, 0, time.UTC) // a Monday
+
+	client := newFakeClient(types.Instance{
+		InstanceId: aws.String("i-start-due"),
+		State:      &types.InstanceState{Name: types.InstanceStateNameStopped},
+		Tags:       []types.Tag{{Key: aws.String(autoStartTag), Value: aws.String("mon-fri:08:00")}},
+	})
+	client.startErr = errors.New("InsufficientInstanceCapacity")
+
+	scheduler := New(client)
+	resp, err := scheduler.Run(context.Background(), now, false)
+	if err != nil {
+		t.Fatalf("expected no top-level error, got %v", err)
+	}
+	if resp.Errored != 1 {
+		t.Errorf("expected 1 errored result, got %+v", resp)
+	}
+}